@@ -0,0 +1,32 @@
+package irc
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	backoffBase = time.Second
+	backoffCap  = 5 * time.Minute
+
+	// stableConnection is how long a connection has to stay up before a
+	// subsequent disconnect resets the backoff attempt counter.
+	stableConnection = 60 * time.Second
+)
+
+// backoffDelay returns a full-jitter exponential backoff delay for the
+// given attempt (1-indexed): a random duration in
+// [0, min(base*2^(attempt-1), cap)).
+func backoffDelay(attempt int) time.Duration {
+	d := backoffBase
+	for i := 1; i < attempt && d < backoffCap; i++ {
+		d *= 2
+	}
+	if d > backoffCap {
+		d = backoffCap
+	}
+	if d <= 0 {
+		return backoffCap
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
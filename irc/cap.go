@@ -0,0 +1,61 @@
+package irc
+
+import "strings"
+
+// handleCap drives the CAP LS 302 -> CAP REQ -> CAP END negotiation
+// started in register.
+func (c *Client) handleCap(msg *Message) {
+	if len(msg.Params) < 2 {
+		return
+	}
+
+	subcommand := msg.Params[1]
+	caps := strings.Fields(msg.Trailing)
+
+	switch subcommand {
+	case "LS":
+		wanted := c.wantedCaps(caps)
+		if len(wanted) == 0 {
+			c.write("CAP END")
+			return
+		}
+		c.writef("CAP REQ :%s", strings.Join(wanted, " "))
+
+	case "ACK":
+		if c.SASL && containsCap(caps, "sasl") {
+			c.startSASL()
+			return
+		}
+		c.write("CAP END")
+
+	case "NAK":
+		c.write("CAP END")
+	}
+}
+
+// wantedCaps returns the subset of available capabilities the client
+// asked to be negotiated.
+func (c *Client) wantedCaps(available []string) []string {
+	want := make([]string, len(c.RequestedCaps))
+	copy(want, c.RequestedCaps)
+	if c.SASL {
+		want = append(want, "sasl")
+	}
+
+	var wanted []string
+	for _, name := range want {
+		if containsCap(available, name) {
+			wanted = append(wanted, name)
+		}
+	}
+	return wanted
+}
+
+func containsCap(caps []string, name string) bool {
+	for _, c := range caps {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,536 @@
+// Package irc implements a minimal IRC client with capability
+// negotiation and SASL authentication.
+package irc
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Priority controls whether a queued line is subject to send rate
+// limiting.
+type Priority int
+
+const (
+	// PriorityNormal messages (PRIVMSG, NOTICE, ...) are paced by the
+	// client's token bucket.
+	PriorityNormal Priority = iota
+	// PriorityHigh messages (PONG, QUIT, registration, ...) bypass the
+	// token bucket entirely.
+	PriorityHigh
+)
+
+// Default token-bucket send rate limiting, applied to PriorityNormal
+// messages.
+const (
+	defaultSendRate  = 2.0
+	defaultSendBurst = 5
+)
+
+// Service is the lifecycle every long-lived Client runs through: Start
+// launches it, Stop requests it wind down, Wait blocks until every
+// goroutine it owns has exited, and Err reports the most recent failure.
+type Service interface {
+	Start() error
+	Stop() error
+	Wait()
+	Err() error
+}
+
+// Client is a connection to a single IRC server. It implements Service:
+// Connect configures the connection and calls Start, Quit queues QUIT and
+// then calls Stop followed by Wait.
+type Client struct {
+	Host      string
+	Server    string
+	TLS       bool
+	TLSConfig *tls.Config
+
+	// ClientCert, when set, is presented during the TLS handshake
+	// (CertFP) and is also what SASL EXTERNAL authenticates with.
+	ClientCert *tls.Certificate
+
+	Nick     string
+	Username string
+	Realname string
+	Password string
+
+	// SASL configures IRCv3 SASL authentication, negotiated as part of
+	// capability negotiation.
+	SASL          bool
+	SASLMechanism string
+	SASLUsername  string
+	SASLPassword  string
+
+	// RequestedCaps are additional capabilities (besides sasl) to
+	// request if the server advertises them.
+	RequestedCaps []string
+
+	// Reconnect controls whether the client attempts to reconnect after
+	// an unexpected disconnect, using an exponential backoff with full
+	// jitter (base 1s, cap 5min). The backoff resets once a connection
+	// has stayed up longer than stableConnection.
+	Reconnect bool
+
+	// SendRate and SendBurst configure the token bucket PriorityNormal
+	// messages are paced through, in messages per second and maximum
+	// burst size.
+	SendRate  float64
+	SendBurst int
+
+	Messages chan *Message
+
+	// Events reports lifecycle transitions (Connecting, Connected,
+	// Disconnected, Reconnecting). It's buffered, and never blocks the
+	// connection loop: events are dropped if nobody's reading.
+	Events chan Event
+
+	conn   net.Conn
+	reader *bufio.Reader
+
+	// connDone is closed by recv when it returns, for whatever reason
+	// (read error or ctx cancellation), so send unwinds alongside it
+	// instead of blocking forever on a dropped connection. Remade for
+	// every connection in runConnection.
+	connDone chan struct{}
+
+	// sendDone is closed by send when it returns, so runConnection's
+	// ctx-watcher can hold off closing conn until send has drained its
+	// highQueue — otherwise the watcher's Close could race the drain's
+	// Write and the flushed line would never reach the wire. Remade for
+	// every connection in runConnection.
+	sendDone chan struct{}
+
+	highQueue chan string
+	normQueue chan string
+	bucket    *tokenBucket
+	queued    int64
+	dropped   int64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	sendRecv sync.WaitGroup
+
+	errMu   sync.Mutex
+	lastErr error
+
+	mu        sync.Mutex
+	connected bool
+}
+
+// NewClient creates a Client ready to Connect.
+func NewClient(nick, username string) *Client {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c := &Client{
+		Nick:     nick,
+		Username: username,
+		Realname: nick,
+
+		SendRate:  defaultSendRate,
+		SendBurst: defaultSendBurst,
+
+		Messages: make(chan *Message, 32),
+		Events:   make(chan Event, 32),
+
+		highQueue: make(chan string, 64),
+		normQueue: make(chan string, 256),
+
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	c.bucket = newTokenBucket(c.SendRate, c.SendBurst)
+	return c
+}
+
+// Connect configures the client to dial addr, defaulting to port 6667
+// (6697 if c.TLS is set) when addr has none, and starts it. The
+// connection is torn down, and any reconnect loop stopped, when ctx is
+// done or Quit is called.
+func (c *Client) Connect(ctx context.Context, addr string) error {
+	host, port := splitAddr(addr, c.TLS)
+	c.Host = host
+	c.Server = host + ":" + port
+
+	c.ctx, c.cancel = context.WithCancel(ctx)
+
+	return c.Start()
+}
+
+// Start launches the client's connection loop. It's called for you by
+// Connect; exported so Client satisfies Service.
+func (c *Client) Start() error {
+	c.wg.Add(1)
+	go c.run()
+	return nil
+}
+
+// Stop cancels the client's context, signalling its connection loop and
+// every goroutine it owns to exit. It does not block; call Wait for
+// that.
+func (c *Client) Stop() error {
+	c.cancel()
+	return nil
+}
+
+// Wait blocks until every goroutine owned by the client has exited.
+func (c *Client) Wait() {
+	c.wg.Wait()
+}
+
+// Err returns the error behind the most recent disconnect, or nil if the
+// client has never disconnected or was last stopped deliberately.
+func (c *Client) Err() error {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	return c.lastErr
+}
+
+func splitAddr(addr string, useTLS bool) (host, port string) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+		if useTLS {
+			port = "6697"
+		} else {
+			port = "6667"
+		}
+	}
+	return host, port
+}
+
+// Connected reports whether the underlying connection is currently up.
+func (c *Client) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected
+}
+
+func (c *Client) setConnected(v bool) {
+	c.mu.Lock()
+	c.connected = v
+	c.mu.Unlock()
+}
+
+func (c *Client) setErr(err error) {
+	c.errMu.Lock()
+	c.lastErr = err
+	c.errMu.Unlock()
+}
+
+// Quit sends QUIT, then stops the client and blocks until every
+// goroutine it owns has exited.
+func (c *Client) Quit() {
+	c.WritePriority(PriorityHigh, "QUIT")
+	c.Stop()
+	c.Wait()
+}
+
+func (c *Client) emit(e Event) {
+	select {
+	case c.Events <- e:
+	default:
+	}
+}
+
+// run dials the server and, while Reconnect is set and ctx isn't done,
+// redials after a disconnect with an exponential backoff.
+func (c *Client) run() {
+	defer c.wg.Done()
+	defer close(c.Messages)
+	defer func() {
+		// A requested stop is not a failure, whatever error recv saw
+		// when the connection was closed under it.
+		if c.ctx.Err() != nil {
+			c.setErr(nil)
+		}
+	}()
+
+	attempt := 0
+	for {
+		if c.ctx.Err() != nil {
+			return
+		}
+
+		c.emit(ConnectingEvent{})
+		conn, err := c.dial()
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return
+			}
+			c.setErr(err)
+			c.emit(DisconnectedEvent{Err: err})
+		} else if c.runConnection(conn) {
+			attempt = 0
+		}
+
+		if c.ctx.Err() != nil || !c.Reconnect {
+			return
+		}
+
+		attempt++
+		delay := backoffDelay(attempt)
+		c.emit(ReconnectingEvent{Attempt: attempt, Delay: delay})
+		if !c.sleep(delay) {
+			return
+		}
+	}
+}
+
+func (c *Client) dial() (net.Conn, error) {
+	if c.TLS {
+		d := tls.Dialer{Config: c.tlsConfig()}
+		return d.DialContext(c.ctx, "tcp", c.Server)
+	}
+	var d net.Dialer
+	return d.DialContext(c.ctx, "tcp", c.Server)
+}
+
+// tlsConfig returns the TLS config to dial with, folding in ClientCert
+// without mutating the caller's TLSConfig.
+func (c *Client) tlsConfig() *tls.Config {
+	if c.ClientCert == nil {
+		return c.TLSConfig
+	}
+
+	cfg := c.TLSConfig.Clone()
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	cfg.Certificates = append(cfg.Certificates, *c.ClientCert)
+	return cfg
+}
+
+// sleep waits out d, returning false if ctx is done first.
+func (c *Client) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
+// runConnection drives a single connection until it goes down, and
+// reports whether it stayed up long enough to count as stable.
+func (c *Client) runConnection(conn net.Conn) bool {
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+	c.bucket = newTokenBucket(c.SendRate, c.SendBurst)
+	c.connDone = make(chan struct{})
+	c.sendDone = make(chan struct{})
+	c.setConnected(true)
+	c.emit(ConnectedEvent{})
+	connectedAt := time.Now()
+
+	// Unblock recv's blocking read as soon as the context is cancelled,
+	// without leaking this goroutine once the connection goes down on
+	// its own. Closing conn is held off until send has returned, so a
+	// QUIT drained from highQueue on cancellation can't lose the race
+	// against this Close and get dropped.
+	closed := make(chan struct{})
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		select {
+		case <-c.ctx.Done():
+			<-c.sendDone
+			conn.Close()
+		case <-closed:
+		}
+	}()
+
+	c.sendRecv.Add(2)
+	go c.send()
+	go c.recv()
+	go c.register()
+
+	c.sendRecv.Wait()
+	close(closed)
+	c.setConnected(false)
+
+	var err error
+	if c.ctx.Err() == nil {
+		// Only a disconnect we didn't ask for carries an error; a
+		// cancelled context means the caller requested the stop.
+		err = c.Err()
+	}
+	c.emit(DisconnectedEvent{Err: err})
+
+	return time.Since(connectedAt) > stableConnection
+}
+
+// write queues a protocol-critical line (PONG, registration, ...) ahead
+// of the send rate limiter.
+func (c *Client) write(s string) {
+	c.enqueue(PriorityHigh, s)
+}
+
+func (c *Client) writef(format string, args ...interface{}) {
+	c.write(fmt.Sprintf(format, args...))
+}
+
+// Write queues a line to be sent to the server, subject to send rate
+// limiting.
+func (c *Client) Write(s string) {
+	c.enqueue(PriorityNormal, s)
+}
+
+// Writef formats and queues a line to be sent to the server, subject to
+// send rate limiting.
+func (c *Client) Writef(format string, args ...interface{}) {
+	c.Write(fmt.Sprintf(format, args...))
+}
+
+// WritePriority queues a formatted line at the given priority, letting
+// callers that need to jump the send queue (e.g. PONG replies outside
+// the irc package) bypass the rate limiter.
+func (c *Client) WritePriority(priority Priority, format string, args ...interface{}) {
+	c.enqueue(priority, fmt.Sprintf(format, args...))
+}
+
+func (c *Client) enqueue(priority Priority, line string) {
+	queue := c.normQueue
+	if priority == PriorityHigh {
+		queue = c.highQueue
+	}
+
+	select {
+	case queue <- line + "\r\n":
+		atomic.AddInt64(&c.queued, 1)
+	default:
+		atomic.AddInt64(&c.dropped, 1)
+	}
+}
+
+// QueuedMessages returns the number of messages currently waiting to be
+// sent.
+func (c *Client) QueuedMessages() int64 {
+	return atomic.LoadInt64(&c.queued)
+}
+
+// DroppedMessages returns the number of messages discarded because the
+// send queue was full.
+func (c *Client) DroppedMessages() int64 {
+	return atomic.LoadInt64(&c.dropped)
+}
+
+func (c *Client) send() {
+	defer c.sendRecv.Done()
+	defer close(c.sendDone)
+
+	for {
+		select {
+		case line := <-c.highQueue:
+			c.sendLine(line)
+			continue
+
+		case <-c.ctx.Done():
+			c.drainHighQueue()
+			return
+
+		case <-c.connDone:
+			c.drainHighQueue()
+			return
+
+		default:
+		}
+
+		select {
+		case line := <-c.highQueue:
+			c.sendLine(line)
+
+		case line := <-c.normQueue:
+			c.bucket.wait()
+			c.sendLine(line)
+
+		case <-c.ctx.Done():
+			c.drainHighQueue()
+			return
+
+		case <-c.connDone:
+			c.drainHighQueue()
+			return
+		}
+	}
+}
+
+// drainHighQueue flushes whatever is already queued on highQueue without
+// blocking. Quit enqueues QUIT then immediately cancels the context, so
+// without this a line enqueued right before cancellation could lose the
+// select's random tiebreak against ctx.Done/connDone and never go out.
+func (c *Client) drainHighQueue() {
+	for {
+		select {
+		case line := <-c.highQueue:
+			c.sendLine(line)
+		default:
+			return
+		}
+	}
+}
+
+func (c *Client) sendLine(line string) {
+	atomic.AddInt64(&c.queued, -1)
+	c.conn.Write([]byte(line))
+}
+
+func (c *Client) recv() {
+	defer c.sendRecv.Done()
+	defer close(c.connDone)
+
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			c.setErr(err)
+			return
+		}
+
+		if msg := ParseMessage(line); msg != nil {
+			c.handleMessage(msg)
+		}
+	}
+}
+
+// register starts IRC registration, advertising capability negotiation
+// support before NICK/USER so the server has a chance to send CAP LS.
+func (c *Client) register() {
+	c.write("CAP LS 302")
+	if c.Password != "" {
+		c.writef("PASS %s", c.Password)
+	}
+	c.writef("NICK %s", c.Nick)
+	c.writef("USER %s 0 * :%s", c.Username, c.Realname)
+}
+
+func (c *Client) handleMessage(msg *Message) {
+	switch msg.Command {
+	case "PING":
+		c.writef("PONG :%s", msg.Trailing)
+
+	case "CAP":
+		c.handleCap(msg)
+
+	case "AUTHENTICATE":
+		c.handleAuthenticate(msg)
+
+	case "900", "903", "904", "906", "908":
+		c.handleSASLResult(msg)
+
+	default:
+		select {
+		case c.Messages <- msg:
+		case <-c.ctx.Done():
+		}
+	}
+}
@@ -3,15 +3,63 @@ package irc
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"log"
 	"net"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+func testClient() *Client {
+	c := NewClient("test", "test")
+	// send and recv close these when they return. runConnection remakes
+	// them for every connection; tests that start send/recv directly
+	// need them in place too.
+	c.connDone = make(chan struct{})
+	c.sendDone = make(chan struct{})
+	return c
+}
+
+// testClientSend returns a Client whose send goroutine is already running
+// against a mockConn, and the channel that mockConn writes are echoed to.
+func testClientSend() (*Client, chan string) {
+	c := testClient()
+	conn := &mockConn{hook: make(chan string, 16)}
+	c.conn = conn
+
+	c.sendRecv.Add(1)
+	go c.send()
+
+	return c, conn.hook
+}
+
+// mockConn is a net.Conn that reports every Write on hook and blocks
+// forever on Read.
+type mockConn struct {
+	hook chan string
+}
+
+func (c *mockConn) Read(b []byte) (int, error) {
+	select {}
+}
+
+func (c *mockConn) Write(b []byte) (int, error) {
+	c.hook <- string(b)
+	return len(b), nil
+}
+
+func (c *mockConn) Close() error                       { return nil }
+func (c *mockConn) LocalAddr() net.Addr                { return nil }
+func (c *mockConn) RemoteAddr() net.Addr               { return nil }
+func (c *mockConn) SetDeadline(t time.Time) error      { return nil }
+func (c *mockConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *mockConn) SetWriteDeadline(t time.Time) error { return nil }
+
 var ircd *mockIrcd
 
 func init() {
@@ -22,6 +70,7 @@ func initTestServer() {
 	ircd = &mockIrcd{
 		conn:       make(chan bool, 1),
 		connClosed: make(chan bool, 1),
+		accepted:   make(chan net.Conn, 16),
 	}
 	ircd.start()
 }
@@ -29,6 +78,12 @@ func initTestServer() {
 type mockIrcd struct {
 	conn       chan bool
 	connClosed chan bool
+
+	// accepted carries the server's end of every connection it accepts,
+	// so a test can sever it itself to simulate an unsolicited
+	// disconnect (as opposed to the client-initiated close the other
+	// tests exercise via Quit).
+	accepted chan net.Conn
 }
 
 func (i *mockIrcd) start() {
@@ -67,6 +122,8 @@ func (i *mockIrcd) accept(ln net.Listener) {
 }
 
 func (i *mockIrcd) handle(conn net.Conn) {
+	i.accepted <- conn
+
 	buf := make([]byte, 1024)
 	for {
 		_, err := conn.Read(buf)
@@ -79,7 +136,8 @@ func (i *mockIrcd) handle(conn net.Conn) {
 
 func TestConnect(t *testing.T) {
 	c := testClient()
-	c.Connect("127.0.0.1:45678")
+	c.Connect(context.Background(), "127.0.0.1:45678")
+	waitForEvent(t, c, ConnectedEvent{})
 	assert.Equal(t, c.Host, "127.0.0.1")
 	assert.Equal(t, c.Server, "127.0.0.1:45678")
 	waitConnAndClose(t, c)
@@ -91,7 +149,8 @@ func TestConnectTLS(t *testing.T) {
 	c.TLSConfig = &tls.Config{
 		InsecureSkipVerify: true,
 	}
-	c.Connect("127.0.0.1:45679")
+	c.Connect(context.Background(), "127.0.0.1:45679")
+	waitForEvent(t, c, ConnectedEvent{})
 	assert.Equal(t, c.Host, "127.0.0.1")
 	assert.Equal(t, c.Server, "127.0.0.1:45679")
 	waitConnAndClose(t, c)
@@ -99,13 +158,31 @@ func TestConnectTLS(t *testing.T) {
 
 func TestConnectDefaultPorts(t *testing.T) {
 	c := testClient()
-	c.Connect("127.0.0.1")
+	c.Connect(context.Background(), "127.0.0.1")
 	assert.Equal(t, "127.0.0.1:6667", c.Server)
+	c.Quit()
 
 	c = testClient()
 	c.TLS = true
-	c.Connect("127.0.0.1")
+	c.Connect(context.Background(), "127.0.0.1")
 	assert.Equal(t, "127.0.0.1:6697", c.Server)
+	c.Quit()
+}
+
+func TestTLSConfigWithClientCert(t *testing.T) {
+	c := testClient()
+	c.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+
+	cert, err := tls.X509KeyPair(testCert, testKey)
+	assert.Nil(t, err)
+	c.ClientCert = &cert
+
+	cfg := c.tlsConfig()
+	assert.True(t, cfg.InsecureSkipVerify)
+	assert.Len(t, cfg.Certificates, 1)
+
+	// The caller's TLSConfig must come back untouched.
+	assert.Len(t, c.TLSConfig.Certificates, 0)
 }
 
 func TestWrite(t *testing.T) {
@@ -131,7 +208,6 @@ func TestRecv(t *testing.T) {
 	buf.WriteString("001\r\n")
 	c.reader = bufio.NewReader(buf)
 
-	c.ready.Add(1)
 	c.sendRecv.Add(2)
 	go c.send()
 	go c.recv()
@@ -140,49 +216,194 @@ func TestRecv(t *testing.T) {
 	assert.Equal(t, &Message{Command: "CMD"}, <-c.Messages)
 }
 
-func TestRecvTriggersReconnect(t *testing.T) {
+func TestRecvSetsErr(t *testing.T) {
 	c := testClient()
 	c.conn = &mockConn{}
-	c.ready.Add(1)
 	c.reader = bufio.NewReader(&bytes.Buffer{})
+
+	c.sendRecv.Add(1)
 	done := make(chan struct{})
-	ok := false
 	go func() {
-		c.sendRecv.Add(1)
 		c.recv()
-		_, ok = <-c.reconnect
 		close(done)
 	}()
 
 	select {
 	case <-done:
-		assert.False(t, ok)
-		return
-
-	case <-time.After(100 * time.Millisecond):
-		t.Error("Reconnect not triggered")
+		assert.Error(t, c.Err())
+	case <-time.After(time.Second):
+		t.Error("recv did not return on EOF")
 	}
 }
 
-func TestClose(t *testing.T) {
+// TestQuitStopsAllGoroutines asserts the Service invariant: once Quit
+// returns, every goroutine the client owns (its connection loop, send,
+// recv, and the context-cancellation watcher) has exited.
+func TestQuitStopsAllGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+
 	c := testClient()
-	close(c.quit)
-	ok := false
-	done := make(chan struct{})
+	c.Connect(context.Background(), "127.0.0.1:45678")
+	waitForEvent(t, c, ConnectedEvent{})
+
+	c.Quit()
+
+	assertNoGoroutineLeak(t, before)
+}
+
+// TestQuitSendsQuit asserts that the QUIT enqueued by Quit is actually
+// written to the connection rather than losing the race between send's
+// queue select and ctx cancellation. It uses its own listener, rather
+// than the shared mockIrcd, because mockIrcd.handle already reads every
+// connection it accepts and would race this test for the bytes.
+func TestQuitSendsQuit(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
 	go func() {
-		_, ok = <-c.Messages
-		close(done)
+		conn, err := ln.Accept()
+		if err == nil {
+			accepted <- conn
+		}
 	}()
 
-	c.run()
+	c := testClient()
+	c.Connect(context.Background(), ln.Addr().String())
+	waitForEvent(t, c, ConnectedEvent{})
+
+	conn := <-accepted
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	c.Quit()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("connection closed before QUIT was seen: %v", err)
+		}
+		if strings.TrimRight(line, "\r\n") == "QUIT" {
+			return
+		}
+	}
+}
+
+func TestReconnectBackoffAndEvents(t *testing.T) {
+	c := testClient()
+	c.Reconnect = true
+	c.Connect(context.Background(), "127.0.0.1:1")
+
+	waitForEvent(t, c, ConnectingEvent{})
 
 	select {
-	case <-done:
-		assert.False(t, ok)
-		return
+	case ev := <-c.Events:
+		if _, ok := ev.(DisconnectedEvent); !ok {
+			t.Fatalf("expected DisconnectedEvent, got %T", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("did not observe a failed dial as a disconnect")
+	}
+
+	select {
+	case ev := <-c.Events:
+		re, ok := ev.(ReconnectingEvent)
+		if !ok {
+			t.Fatalf("expected ReconnectingEvent, got %T", ev)
+		}
+		assert.Equal(t, 1, re.Attempt)
+	case <-time.After(time.Second):
+		t.Fatal("did not observe a reconnect attempt")
+	}
+
+	c.Quit()
+}
+
+// TestReconnectAfterDrop asserts that a server-initiated disconnect on an
+// already-established connection (no Quit, no dial error) still unwinds
+// both the send and recv goroutines and drives the reconnect loop, rather
+// than leaving send() parked on the queue select forever.
+func TestReconnectAfterDrop(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	// Discard what earlier tests left on the shared mockIrcd, so the
+	// connection severed below is this client's own.
+	for len(ircd.accepted) > 0 {
+		<-ircd.accepted
+	}
+	for len(ircd.connClosed) > 0 {
+		<-ircd.connClosed
+	}
+	for len(ircd.conn) > 0 {
+		<-ircd.conn
+	}
+
+	c := testClient()
+	c.Reconnect = true
+	c.Connect(context.Background(), "127.0.0.1:45678")
+
+	waitForEvent(t, c, ConnectedEvent{})
+	(<-ircd.accepted).Close()
+	// Let the mock's handler for the severed connection report it and
+	// exit, so the reconnected one's handler can do the same after Quit.
+	select {
+	case <-ircd.connClosed:
+	case <-time.After(time.Second):
+		t.Fatal("mock server never saw the severed connection close")
+	}
+
+	select {
+	case ev := <-c.Events:
+		de, ok := ev.(DisconnectedEvent)
+		if !ok {
+			t.Fatalf("expected DisconnectedEvent, got %T", ev)
+		}
+		assert.Error(t, de.Err)
+	case <-time.After(time.Second):
+		t.Fatal("did not observe the dropped connection as a disconnect")
+	}
+
+	select {
+	case ev := <-c.Events:
+		if _, ok := ev.(ReconnectingEvent); !ok {
+			t.Fatalf("expected ReconnectingEvent, got %T", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("did not observe a reconnect attempt")
+	}
+
+	waitForEvent(t, c, ConnectedEvent{})
+
+	c.Quit()
+	assertNoGoroutineLeak(t, before)
+}
 
-	case <-time.After(100 * time.Millisecond):
-		t.Error("Channels not closed")
+func waitForEvent(t *testing.T, c *Client, want Event) {
+	for {
+		select {
+		case ev := <-c.Events:
+			if ev == want {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("never observed %T", want)
+		}
+	}
+}
+
+func assertNoGoroutineLeak(t *testing.T, before int) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		if runtime.NumGoroutine() <= before {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("goroutine leak: have %d, want <= %d", runtime.NumGoroutine(), before)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
 	}
 }
 
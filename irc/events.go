@@ -0,0 +1,36 @@
+package irc
+
+import "time"
+
+// Event is emitted on Client.Events as the connection moves through its
+// lifecycle.
+type Event interface {
+	isEvent()
+}
+
+// ConnectingEvent is emitted before each dial attempt, including retries.
+type ConnectingEvent struct{}
+
+// ConnectedEvent is emitted once the connection is up, just before
+// registration starts.
+type ConnectedEvent struct{}
+
+// DisconnectedEvent is emitted when the connection goes down or a dial
+// fails, whether from a network error or the server closing it. A failed
+// SASL negotiation does not close the connection. Err is nil when the
+// disconnect was requested by the caller.
+type DisconnectedEvent struct {
+	Err error
+}
+
+// ReconnectingEvent is emitted after a disconnect, once Reconnect has
+// decided to wait Delay before dial attempt number Attempt.
+type ReconnectingEvent struct {
+	Attempt int
+	Delay   time.Duration
+}
+
+func (ConnectingEvent) isEvent()   {}
+func (ConnectedEvent) isEvent()    {}
+func (DisconnectedEvent) isEvent() {}
+func (ReconnectingEvent) isEvent() {}
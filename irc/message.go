@@ -0,0 +1,81 @@
+package irc
+
+import "strings"
+
+// Message is a single parsed IRC protocol line.
+type Message struct {
+	Prefix  string
+	Nick    string
+	User    string
+	Host    string
+	Command string
+	Params  []string
+
+	Trailing string
+}
+
+// ParseMessage parses a single raw IRC line into a Message. It returns nil
+// for empty lines.
+func ParseMessage(line string) *Message {
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil
+	}
+
+	msg := &Message{}
+
+	if strings.HasPrefix(line, ":") {
+		var prefix string
+		prefix, line = split(line[1:])
+		msg.Prefix = prefix
+		msg.Nick, msg.User, msg.Host = splitPrefix(prefix)
+	}
+
+	if idx := strings.Index(line, " :"); idx != -1 {
+		msg.Trailing = line[idx+2:]
+		line = line[:idx]
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	msg.Command = strings.ToUpper(fields[0])
+	if len(fields) > 1 {
+		msg.Params = fields[1:]
+	}
+	if msg.Trailing != "" {
+		msg.Params = append(msg.Params, msg.Trailing)
+	}
+
+	return msg
+}
+
+func split(s string) (first, rest string) {
+	idx := strings.Index(s, " ")
+	if idx == -1 {
+		return s, ""
+	}
+	return s[:idx], strings.TrimLeft(s[idx+1:], " ")
+}
+
+func splitPrefix(prefix string) (nick, user, host string) {
+	if idx := strings.Index(prefix, "!"); idx != -1 {
+		nick = prefix[:idx]
+		prefix = prefix[idx+1:]
+	}
+
+	if idx := strings.Index(prefix, "@"); idx != -1 {
+		if nick == "" {
+			nick = prefix[:idx]
+		} else {
+			user = prefix[:idx]
+		}
+		host = prefix[idx+1:]
+	} else if nick == "" {
+		nick = prefix
+	}
+
+	return
+}
@@ -0,0 +1,62 @@
+package irc
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket paces PriorityNormal sends to at most rate messages per
+// second, allowing bursts of up to burst messages.
+type tokenBucket struct {
+	mu       sync.Mutex
+	tokens   float64
+	capacity float64
+	rate     float64
+	last     time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		rate = defaultSendRate
+	}
+	if burst <= 0 {
+		burst = defaultSendBurst
+	}
+
+	return &tokenBucket{
+		tokens:   float64(burst),
+		capacity: float64(burst),
+		rate:     rate,
+		last:     time.Now(),
+	}
+}
+
+// take reserves a single token, returning how long the caller must wait
+// before it's actually available.
+func (b *tokenBucket) take() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	b.tokens--
+	return wait
+}
+
+// wait blocks until a token is available.
+func (b *tokenBucket) wait() {
+	if d := b.take(); d > 0 {
+		time.Sleep(d)
+	}
+}
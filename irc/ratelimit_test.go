@@ -0,0 +1,68 @@
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWritePaced(t *testing.T) {
+	c, out := testClientSend()
+	c.SendRate = 100
+	c.SendBurst = 5
+	c.bucket = newTokenBucket(c.SendRate, c.SendBurst)
+
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		c.Writef("PRIVMSG #test :message %d", i)
+	}
+
+	for i := 0; i < 20; i++ {
+		select {
+		case <-out:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("message %d not sent", i)
+		}
+	}
+
+	elapsed := time.Since(start)
+	min := time.Duration(float64(20-c.SendBurst) / c.SendRate * float64(time.Second))
+	assert.True(t, elapsed >= min, "writes were not paced: took %s, wanted at least %s", elapsed, min)
+}
+
+func TestWritePriorityBypassesRateLimit(t *testing.T) {
+	c, out := testClientSend()
+	c.SendRate = 1
+	c.SendBurst = 1
+	c.bucket = newTokenBucket(c.SendRate, c.SendBurst)
+
+	// Exhaust the burst so any further PriorityNormal write would have
+	// to wait roughly a second.
+	c.Write("PRIVMSG #test :hello")
+	<-out
+
+	start := time.Now()
+	c.WritePriority(PriorityHigh, "PONG :%s", "test")
+
+	select {
+	case line := <-out:
+		assert.Equal(t, "PONG :test\r\n", line)
+	case <-time.After(time.Second):
+		t.Fatal("high priority write was throttled")
+	}
+	assert.True(t, time.Since(start) < 500*time.Millisecond)
+}
+
+func TestQueuedAndDroppedMessages(t *testing.T) {
+	c := testClient()
+	c.normQueue = make(chan string, 1)
+
+	c.Write("first")
+	assert.EqualValues(t, 1, c.QueuedMessages())
+	assert.EqualValues(t, 0, c.DroppedMessages())
+
+	c.Write("second")
+	assert.EqualValues(t, 1, c.QueuedMessages())
+	assert.EqualValues(t, 1, c.DroppedMessages())
+}
@@ -0,0 +1,57 @@
+package irc
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// startSASL kicks off authentication for the mechanism configured on the
+// client, defaulting to PLAIN.
+func (c *Client) startSASL() {
+	mechanism := strings.ToUpper(c.SASLMechanism)
+	if mechanism == "" {
+		mechanism = "PLAIN"
+	}
+	c.writef("AUTHENTICATE %s", mechanism)
+}
+
+// handleAuthenticate replies to the server's AUTHENTICATE + prompt with
+// the credentials for the negotiated mechanism.
+func (c *Client) handleAuthenticate(msg *Message) {
+	if len(msg.Params) == 0 || msg.Params[0] != "+" {
+		return
+	}
+
+	switch strings.ToUpper(c.SASLMechanism) {
+	case "EXTERNAL":
+		// The identity is established via the TLS client certificate,
+		// so the authentication data itself is empty.
+		c.write("AUTHENTICATE +")
+
+	default:
+		payload := "\x00" + c.SASLUsername + "\x00" + c.SASLPassword
+		c.writef("AUTHENTICATE %s", base64.StdEncoding.EncodeToString([]byte(payload)))
+	}
+}
+
+// handleSASLResult reacts to the AUTHENTICATE numerics (900/903/904/906/908),
+// ending capability negotiation on success or failure.
+func (c *Client) handleSASLResult(msg *Message) {
+	switch msg.Command {
+	case "900", "903":
+		c.write("CAP END")
+
+	case "904", "906", "908":
+		c.Messages <- &Message{
+			Command:  "ERROR",
+			Trailing: "SASL authentication failed: " + msg.Trailing,
+		}
+
+		// Bad credentials aren't a transient failure: closing the
+		// connection here would just have the reconnect loop redial and
+		// retry the same SASLUsername/SASLPassword forever. End
+		// capability negotiation instead and let the server decide
+		// whether the connection may continue unauthenticated.
+		c.write("CAP END")
+	}
+}
@@ -0,0 +1,168 @@
+package irc
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testSASLClient wires up a Client against one end of a net.Pipe and
+// starts its send/recv goroutines, returning a reader for the other end
+// so the test can play the part of the ircd.
+func testSASLClient(t *testing.T) (*Client, net.Conn, *bufio.Reader) {
+	client, server := net.Pipe()
+
+	c := testClient()
+	c.conn = client
+	c.reader = bufio.NewReader(client)
+
+	c.sendRecv.Add(2)
+	go c.send()
+	go c.recv()
+
+	return c, server, bufio.NewReader(server)
+}
+
+func assertLine(t *testing.T, r *bufio.Reader, expected string) {
+	line, err := r.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, expected, strings.TrimRight(line, "\r\n"))
+}
+
+func TestSASLPlain(t *testing.T) {
+	c, server, serverReader := testSASLClient(t)
+	defer server.Close()
+	c.SASL = true
+	c.SASLUsername = "bob"
+	c.SASLPassword = "hunter2"
+
+	go c.register()
+
+	assertLine(t, serverReader, "CAP LS 302")
+	assertLine(t, serverReader, "NICK test")
+	assertLine(t, serverReader, "USER test 0 * :test")
+
+	server.Write([]byte("CAP * LS :sasl\r\n"))
+	assertLine(t, serverReader, "CAP REQ :sasl")
+
+	server.Write([]byte("CAP * ACK :sasl\r\n"))
+	assertLine(t, serverReader, "AUTHENTICATE PLAIN")
+
+	server.Write([]byte("AUTHENTICATE +\r\n"))
+	want := base64.StdEncoding.EncodeToString([]byte("\x00bob\x00hunter2"))
+	assertLine(t, serverReader, "AUTHENTICATE "+want)
+
+	server.Write([]byte(":irc.example.com 903 test :SASL authentication successful\r\n"))
+	assertLine(t, serverReader, "CAP END")
+
+	c.cancel()
+}
+
+func TestSASLExternal(t *testing.T) {
+	c, server, serverReader := testSASLClient(t)
+	defer server.Close()
+	c.SASL = true
+	c.SASLMechanism = "EXTERNAL"
+
+	go c.register()
+
+	assertLine(t, serverReader, "CAP LS 302")
+	assertLine(t, serverReader, "NICK test")
+	assertLine(t, serverReader, "USER test 0 * :test")
+
+	server.Write([]byte("CAP * LS :sasl\r\n"))
+	assertLine(t, serverReader, "CAP REQ :sasl")
+
+	server.Write([]byte("CAP * ACK :sasl\r\n"))
+	assertLine(t, serverReader, "AUTHENTICATE EXTERNAL")
+
+	server.Write([]byte("AUTHENTICATE +\r\n"))
+	assertLine(t, serverReader, "AUTHENTICATE +")
+
+	server.Write([]byte(":irc.example.com 900 test test bob :You are now logged in\r\n"))
+	assertLine(t, serverReader, "CAP END")
+
+	c.cancel()
+}
+
+func TestSASLFailure(t *testing.T) {
+	c, server, serverReader := testSASLClient(t)
+	defer server.Close()
+	c.SASL = true
+	c.SASLUsername = "bob"
+	c.SASLPassword = "wrong"
+
+	go c.register()
+
+	assertLine(t, serverReader, "CAP LS 302")
+	assertLine(t, serverReader, "NICK test")
+	assertLine(t, serverReader, "USER test 0 * :test")
+
+	server.Write([]byte("CAP * LS :sasl\r\n"))
+	assertLine(t, serverReader, "CAP REQ :sasl")
+
+	server.Write([]byte("CAP * ACK :sasl\r\n"))
+	assertLine(t, serverReader, "AUTHENTICATE PLAIN")
+
+	server.Write([]byte("AUTHENTICATE +\r\n"))
+	serverReader.ReadString('\n')
+
+	server.Write([]byte(":irc.example.com 904 test :SASL authentication failed\r\n"))
+
+	select {
+	case msg := <-c.Messages:
+		assert.Equal(t, "ERROR", msg.Command)
+	case <-time.After(time.Second):
+		t.Error("SASL failure not surfaced on Messages")
+	}
+
+	assertLine(t, serverReader, "CAP END")
+
+	c.cancel()
+}
+
+// TestSASLFailureWithReconnect asserts that a failed SASL negotiation
+// doesn't close the connection even when Reconnect is set, since bad
+// credentials aren't transient and closing here would just spin the
+// reconnect loop retrying the same ones forever.
+func TestSASLFailureWithReconnect(t *testing.T) {
+	c, server, serverReader := testSASLClient(t)
+	defer server.Close()
+	c.SASL = true
+	c.Reconnect = true
+	c.SASLUsername = "bob"
+	c.SASLPassword = "wrong"
+
+	go c.register()
+
+	assertLine(t, serverReader, "CAP LS 302")
+	assertLine(t, serverReader, "NICK test")
+	assertLine(t, serverReader, "USER test 0 * :test")
+
+	server.Write([]byte("CAP * LS :sasl\r\n"))
+	assertLine(t, serverReader, "CAP REQ :sasl")
+
+	server.Write([]byte("CAP * ACK :sasl\r\n"))
+	assertLine(t, serverReader, "AUTHENTICATE PLAIN")
+
+	server.Write([]byte("AUTHENTICATE +\r\n"))
+	serverReader.ReadString('\n')
+
+	server.Write([]byte(":irc.example.com 904 test :SASL authentication failed\r\n"))
+
+	select {
+	case msg := <-c.Messages:
+		assert.Equal(t, "ERROR", msg.Command)
+	case <-time.After(time.Second):
+		t.Error("SASL failure not surfaced on Messages")
+	}
+
+	assertLine(t, serverReader, "CAP END")
+
+	c.cancel()
+}
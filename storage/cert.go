@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/khlieng/dispatch/Godeps/_workspace/src/github.com/boltdb/bolt"
+)
+
+// certValidity is how long a generated client certificate remains
+// valid. CertFP only cares about the key, not the expiry, so this is
+// generous.
+const certValidity = 10 * 365 * 24 * time.Hour
+
+// GenerateCert creates a self-signed client certificate for server,
+// stores it under this user and returns its SHA-256 fingerprint
+// (hex-encoded, as used for IRCv3 CertFP, e.g. NickServ CERT ADD).
+// Calling it again replaces any certificate already stored for server.
+func (u *User) GenerateCert(server string) (string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: server},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(certValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	pem.Encode(&buf, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	err = u.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketCerts).Put([]byte(server), buf.Bytes())
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return fingerprint(der), nil
+}
+
+// Cert returns the client certificate GenerateCert stored for server.
+func (u *User) Cert(server string) (*tls.Certificate, error) {
+	var data []byte
+
+	err := u.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketCerts).Get([]byte(server))
+		if v != nil {
+			data = append([]byte{}, v...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, errors.New("storage: no client certificate for " + server)
+	}
+
+	return parseCert(data)
+}
+
+// Fingerprint returns the SHA-256 fingerprint of cert's leaf
+// certificate, in the hex format used for IRCv3 CertFP.
+func Fingerprint(cert *tls.Certificate) (string, error) {
+	if len(cert.Certificate) == 0 {
+		return "", errors.New("storage: certificate has no leaf")
+	}
+	return fingerprint(cert.Certificate[0]), nil
+}
+
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// parseCert decodes a PEM blob holding a certificate chain and its
+// private key, tolerating either block order and chains of more than
+// one certificate, and verifies the key actually matches the leaf
+// certificate.
+func parseCert(data []byte) (*tls.Certificate, error) {
+	var cert tls.Certificate
+	var keyDER []byte
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+		case "CERTIFICATE":
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		case "RSA PRIVATE KEY":
+			keyDER = block.Bytes
+		}
+	}
+
+	if len(cert.Certificate) == 0 {
+		return nil, errors.New("storage: no certificate found in PEM data")
+	}
+	if keyDER == nil {
+		return nil, errors.New("storage: no private key found in PEM data")
+	}
+
+	key, err := x509.ParsePKCS1PrivateKey(keyDER)
+	if err != nil {
+		return nil, err
+	}
+	cert.PrivateKey = key
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	cert.Leaf = leaf
+
+	pub, ok := leaf.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("storage: certificate does not hold an RSA public key")
+	}
+	if pub.N.Cmp(key.N) != 0 || pub.E != key.E {
+		return nil, errors.New("storage: certificate and private key don't match")
+	}
+
+	return &cert, nil
+}
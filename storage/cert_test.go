@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/khlieng/dispatch/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+)
+
+func TestGenerateAndLoadCert(t *testing.T) {
+	Initialize(tempdir())
+	Open()
+
+	user, err := NewUser()
+	assert.Nil(t, err)
+
+	fingerprint, err := user.GenerateCert("irc.freenode.net")
+	assert.Nil(t, err)
+	assert.Len(t, fingerprint, 64)
+
+	cert, err := user.Cert("irc.freenode.net")
+	assert.Nil(t, err)
+	assert.NotNil(t, cert.PrivateKey)
+
+	got, err := Fingerprint(cert)
+	assert.Nil(t, err)
+	assert.Equal(t, fingerprint, got)
+
+	Close()
+}
+
+func TestCertMissing(t *testing.T) {
+	Initialize(tempdir())
+	Open()
+
+	user, err := NewUser()
+	assert.Nil(t, err)
+
+	_, err = user.Cert("irc.freenode.net")
+	assert.NotNil(t, err)
+
+	Close()
+}
+
+func TestParseCertRejectsMismatchedKey(t *testing.T) {
+	Initialize(tempdir())
+	Open()
+
+	userA, err := NewUser()
+	assert.Nil(t, err)
+	userB, err := NewUser()
+	assert.Nil(t, err)
+
+	_, err = userA.GenerateCert("irc.freenode.net")
+	assert.Nil(t, err)
+	_, err = userB.GenerateCert("irc.freenode.net")
+	assert.Nil(t, err)
+
+	// Graft user B's certificate onto user A's stored private key and
+	// confirm parseCert catches the mismatch.
+	certA, err := userA.Cert("irc.freenode.net")
+	assert.Nil(t, err)
+	certB, err := userB.Cert("irc.freenode.net")
+	assert.Nil(t, err)
+
+	var mismatched bytes.Buffer
+	pem.Encode(&mismatched, &pem.Block{Type: "CERTIFICATE", Bytes: certB.Certificate[0]})
+	pem.Encode(&mismatched, &pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(certA.PrivateKey.(*rsa.PrivateKey)),
+	})
+
+	_, err = parseCert(mismatched.Bytes())
+	assert.NotNil(t, err)
+
+	Close()
+}
@@ -0,0 +1,8 @@
+package storage
+
+// Channel identifies a channel a user has joined on a particular server.
+type Channel struct {
+	Server   string
+	Name     string
+	Password string
+}
@@ -0,0 +1,17 @@
+package storage
+
+import "time"
+
+// Message is a single logged line from a channel or query.
+type Message struct {
+	ID      uint64
+	Server  string
+	From    string
+	To      string
+	Content string
+	Time    time.Time
+
+	// Highlights is populated by SearchMessages with the byte ranges in
+	// Content that matched the query.
+	Highlights []Highlight `json:"-"`
+}
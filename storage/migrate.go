@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"encoding/json"
+
+	"github.com/khlieng/dispatch/Godeps/_workspace/src/github.com/boltdb/bolt"
+)
+
+var keyIndexed = []byte("indexed")
+
+// migrateSearchIndex builds the search index for any logs written before
+// it existed. It is a no-op once the index has been built.
+func migrateSearchIndex(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(bucketMeta)
+		if meta.Get(keyIndexed) != nil {
+			return nil
+		}
+
+		logs := tx.Bucket(bucketLogs)
+		index := tx.Bucket(bucketIndex)
+
+		err := logs.ForEach(func(name, v []byte) error {
+			if v != nil {
+				return nil
+			}
+
+			logBucket := logs.Bucket(name)
+			indexBucket, err := index.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+
+			return logBucket.ForEach(func(idKey, data []byte) error {
+				var msg Message
+				if err := json.Unmarshal(data, &msg); err != nil {
+					return err
+				}
+				return indexTokens(indexBucket, tokenize(msg.Content), msg.ID)
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		return meta.Put(keyIndexed, []byte{1})
+	})
+}
@@ -0,0 +1,375 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/khlieng/dispatch/Godeps/_workspace/src/github.com/boltdb/bolt"
+)
+
+// errInvalidCursor is returned by SearchMessages when a BeforeID/AfterID
+// cursor can't be resolved to a logged message, e.g. a cross-channel
+// search missing its BeforeChannel/AfterChannel, or a channel deleted
+// since the cursor was issued.
+var errInvalidCursor = errors.New("storage: invalid search cursor")
+
+// TimeRange restricts a search to messages logged within [Start, End].
+// A zero value for either bound leaves that side unrestricted.
+type TimeRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+// SearchOptions narrows a SearchMessages query. BeforeID/AfterID page
+// through results oldest/newest relative to a previous SearchResult's
+// Cursor. Ids are only unique within a single channel's log, so a
+// cross-channel search (channel == "" in SearchMessages) must pair them
+// with BeforeChannel/AfterChannel, set from the matching SearchResult's
+// CursorChannel.
+type SearchOptions struct {
+	Limit         int
+	BeforeID      uint64
+	BeforeChannel string
+	AfterID       uint64
+	AfterChannel  string
+	Nick          string
+	TimeRange     TimeRange
+}
+
+// Highlight is a byte range in Message.Content that matched a search
+// query, for client-side highlighting.
+type Highlight struct {
+	Start int
+	End   int
+}
+
+// SearchResult is a page of search hits. Cursor and CursorChannel
+// identify the oldest message returned, to carry forward as
+// BeforeID/BeforeChannel on the next page.
+type SearchResult struct {
+	Messages      []Message
+	Total         int
+	Cursor        uint64
+	CursorChannel string
+}
+
+type searchHit struct {
+	channel string
+	id      uint64
+}
+
+// channelsForServer returns the channel names logged under server,
+// derived from the logs bucket's own nested-bucket names.
+func channelsForServer(logs *bolt.Bucket, server string) []string {
+	var channels []string
+
+	prefix := server + "\x00"
+	c := logs.Cursor()
+	for k, v := c.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, v = c.Next() {
+		if v != nil {
+			continue
+		}
+		channels = append(channels, strings.TrimPrefix(string(k), prefix))
+	}
+
+	return channels
+}
+
+// SearchMessages looks up query in the given channel's message log, or
+// across every channel on server if channel is empty. Results are
+// ordered newest first by Time, since ids are only sequential within a
+// single channel's log and collide across channels.
+func (u *User) SearchMessages(server, channel, query string, opts SearchOptions) (SearchResult, error) {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return SearchResult{}, nil
+	}
+
+	var hits []searchHit
+
+	err := u.db.View(func(tx *bolt.Tx) error {
+		index := tx.Bucket(bucketIndex)
+
+		channels := []string{channel}
+		if channel == "" {
+			channels = channelsForServer(tx.Bucket(bucketLogs), server)
+		}
+
+		for _, ch := range channels {
+			bucket := index.Bucket(logKey(server, ch))
+			if bucket == nil {
+				continue
+			}
+			for _, id := range postingsForTerms(bucket, terms) {
+				hits = append(hits, searchHit{channel: ch, id: id})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return SearchResult{}, err
+	}
+
+	var result SearchResult
+
+	err = u.db.View(func(tx *bolt.Tx) error {
+		logs := tx.Bucket(bucketLogs)
+
+		var before, after *Message
+		if opts.BeforeID != 0 {
+			msg, ok, err := getLoggedMessage(logs, server, cursorChannel(opts.BeforeChannel, channel), opts.BeforeID)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return errInvalidCursor
+			}
+			before = &msg
+		}
+		if opts.AfterID != 0 {
+			msg, ok, err := getLoggedMessage(logs, server, cursorChannel(opts.AfterChannel, channel), opts.AfterID)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return errInvalidCursor
+			}
+			after = &msg
+		}
+
+		msgs := make([]Message, 0, len(hits))
+		for _, hit := range hits {
+			msg, ok, err := getLoggedMessage(logs, server, hit.channel, hit.id)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+
+			if before != nil && !searchOrderLess(*before, msg) {
+				continue
+			}
+			if after != nil && !searchOrderLess(msg, *after) {
+				continue
+			}
+			if opts.Nick != "" && msg.From != opts.Nick {
+				continue
+			}
+			if !opts.TimeRange.Start.IsZero() && msg.Time.Before(opts.TimeRange.Start) {
+				continue
+			}
+			if !opts.TimeRange.End.IsZero() && msg.Time.After(opts.TimeRange.End) {
+				continue
+			}
+
+			msgs = append(msgs, msg)
+		}
+
+		sort.Slice(msgs, func(i, j int) bool { return searchOrderLess(msgs[i], msgs[j]) })
+
+		result.Total = len(msgs)
+		if len(msgs) > limit {
+			msgs = msgs[:limit]
+		}
+		for i := range msgs {
+			msgs[i].Highlights = highlight(msgs[i].Content, terms)
+		}
+		result.Messages = msgs
+
+		return nil
+	})
+	if err != nil {
+		return SearchResult{}, err
+	}
+
+	if len(result.Messages) > 0 {
+		last := result.Messages[len(result.Messages)-1]
+		result.Cursor = last.ID
+		result.CursorChannel = last.To
+	}
+
+	return result, nil
+}
+
+// getLoggedMessage looks up a single logged message by its channel log
+// and sequence id. ok is false when no such message exists; err is set
+// only if one exists but its stored JSON is corrupt.
+func getLoggedMessage(logs *bolt.Bucket, server, channel string, id uint64) (msg Message, ok bool, err error) {
+	logBucket := logs.Bucket(logKey(server, channel))
+	if logBucket == nil {
+		return Message{}, false, nil
+	}
+
+	data := logBucket.Get(idToBytes(id))
+	if data == nil {
+		return Message{}, false, nil
+	}
+
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return Message{}, false, err
+	}
+
+	return msg, true, nil
+}
+
+// cursorChannel resolves the channel a BeforeID/AfterID belongs to: the
+// explicit BeforeChannel/AfterChannel if given, otherwise the channel
+// being searched (unambiguous for a single-channel search).
+func cursorChannel(explicit, channel string) string {
+	if explicit != "" {
+		return explicit
+	}
+	return channel
+}
+
+// searchOrderLess reports whether a sorts before b in search results:
+// newest Time first, falling back to channel then id so the order is
+// total even when two messages share a timestamp.
+func searchOrderLess(a, b Message) bool {
+	if !a.Time.Equal(b.Time) {
+		return a.Time.After(b.Time)
+	}
+	if a.To != b.To {
+		return a.To > b.To
+	}
+	return a.ID > b.ID
+}
+
+// tokenize lowercases s and splits it into the same terms indexTokens
+// indexes message content by.
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// indexTokens adds id to the postings list of every token, skipping
+// repeats within the same message.
+func indexTokens(bucket *bolt.Bucket, tokens []string, id uint64) error {
+	seen := make(map[string]bool, len(tokens))
+
+	for _, token := range tokens {
+		if seen[token] {
+			continue
+		}
+		seen[token] = true
+
+		existing := bucket.Get([]byte(token))
+		postings := make([]byte, len(existing)+8)
+		copy(postings, existing)
+		binary.BigEndian.PutUint64(postings[len(existing):], id)
+
+		if err := bucket.Put([]byte(token), postings); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func decodePostings(data []byte) []uint64 {
+	ids := make([]uint64, len(data)/8)
+	for i := range ids {
+		ids[i] = binary.BigEndian.Uint64(data[i*8 : i*8+8])
+	}
+	return ids
+}
+
+// postingsForTerms returns the intersection of the postings lists for
+// every term, i.e. the ids of messages containing a token prefixed by
+// each of them.
+func postingsForTerms(bucket *bolt.Bucket, terms []string) []uint64 {
+	postings := postingsForPrefix(bucket, terms[0])
+
+	for _, term := range terms[1:] {
+		postings = intersectPostings(postings, postingsForPrefix(bucket, term))
+		if len(postings) == 0 {
+			break
+		}
+	}
+
+	return postings
+}
+
+// postingsForPrefix returns the sorted, deduplicated union of the
+// postings lists of every indexed token starting with prefix.
+func postingsForPrefix(bucket *bolt.Bucket, prefix string) []uint64 {
+	var postings []uint64
+
+	c := bucket.Cursor()
+	key := []byte(prefix)
+	for k, v := c.Seek(key); k != nil && strings.HasPrefix(string(k), prefix); k, v = c.Next() {
+		postings = append(postings, decodePostings(v)...)
+	}
+
+	sort.Slice(postings, func(i, j int) bool { return postings[i] < postings[j] })
+
+	return dedupeSorted(postings)
+}
+
+func dedupeSorted(ids []uint64) []uint64 {
+	if len(ids) == 0 {
+		return ids
+	}
+
+	deduped := ids[:1]
+	for _, id := range ids[1:] {
+		if id != deduped[len(deduped)-1] {
+			deduped = append(deduped, id)
+		}
+	}
+	return deduped
+}
+
+// intersectPostings merges two postings lists, both sorted ascending.
+func intersectPostings(a, b []uint64) []uint64 {
+	var result []uint64
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			result = append(result, a[i])
+			i++
+			j++
+		case a[i] < b[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return result
+}
+
+// highlight returns the byte ranges in content that match any of terms,
+// ordered by position.
+func highlight(content string, terms []string) []Highlight {
+	lower := strings.ToLower(content)
+
+	var marks []Highlight
+	for _, term := range terms {
+		for start := 0; ; {
+			idx := strings.Index(lower[start:], term)
+			if idx == -1 {
+				break
+			}
+			marks = append(marks, Highlight{Start: start + idx, End: start + idx + len(term)})
+			start += idx + len(term)
+		}
+	}
+
+	sort.Slice(marks, func(i, j int) bool { return marks[i].Start < marks[j].Start })
+
+	return marks
+}
@@ -0,0 +1,25 @@
+package storage
+
+// Server holds the configuration needed to connect to an IRC network.
+type Server struct {
+	Name     string
+	Host     string
+	Port     int
+	TLS      bool
+	Password string
+	Nick     string
+	Username string
+	Realname string
+
+	// SASL, when true, authenticates using SASLMechanism ("PLAIN" or
+	// "EXTERNAL") once irc.Client negotiates the sasl capability.
+	SASL          bool
+	SASLMechanism string
+	SASLUsername  string
+	SASLPassword  string
+
+	// UseClientCert enables SASL EXTERNAL / CertFP authentication with
+	// the client certificate User.GenerateCert created for this
+	// server's Host.
+	UseClientCert bool
+}
@@ -0,0 +1,110 @@
+// Package storage persists users, their server/channel lists and logged
+// messages to a set of BoltDB files on disk.
+package storage
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/khlieng/dispatch/Godeps/_workspace/src/github.com/boltdb/bolt"
+)
+
+var (
+	dataDir string
+	index   *bolt.DB
+)
+
+var bucketUsers = []byte("users")
+
+// Initialize sets the directory all user data is stored under. It must be
+// called before Open.
+func Initialize(dir string) {
+	dataDir = dir
+}
+
+// Open opens the user index database, creating dataDir if necessary.
+func Open() error {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return err
+	}
+
+	db, err := bolt.Open(filepath.Join(dataDir, "index.db"), 0600, nil)
+	if err != nil {
+		return err
+	}
+	index = db
+
+	return index.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketUsers)
+		return err
+	})
+}
+
+// Close closes the user index database.
+func Close() error {
+	if index == nil {
+		return nil
+	}
+	err := index.Close()
+	index = nil
+	return err
+}
+
+// NewUser allocates a new user ID and opens that user's database.
+func NewUser() (*User, error) {
+	var id uint64
+
+	err := index.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketUsers)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		id = seq
+		return bucket.Put(idToBytes(id), []byte{})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return openUser(id)
+}
+
+// LoadUsers opens every user previously created with NewUser.
+func LoadUsers() []*User {
+	var ids []uint64
+
+	index.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketUsers).ForEach(func(k, v []byte) error {
+			ids = append(ids, binary.BigEndian.Uint64(k))
+			return nil
+		})
+	})
+
+	users := make([]*User, 0, len(ids))
+	for _, id := range ids {
+		user, err := openUser(id)
+		if err != nil {
+			continue
+		}
+		users = append(users, user)
+	}
+
+	return users
+}
+
+func userDir(id uint64) string {
+	return filepath.Join(dataDir, strconv.FormatUint(id, 10))
+}
+
+func createDir(dir string) error {
+	return os.MkdirAll(dir, 0755)
+}
+
+func idToBytes(id uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, id)
+	return b
+}
@@ -0,0 +1,110 @@
+package storage
+
+import "sync/atomic"
+
+// subscriptionBuffer is the number of messages buffered per Subscription
+// before the oldest one is dropped to make room for a new one.
+const subscriptionBuffer = 64
+
+// SubscribeOptions configures a Subscription.
+type SubscribeOptions struct {
+	// Replay sends up to this many of the most recent messages already
+	// logged for the channel before any new ones.
+	Replay int
+}
+
+// Subscription streams messages logged to a channel as they're written.
+type Subscription struct {
+	C chan Message
+
+	// Dropped counts messages dropped because the subscriber wasn't
+	// keeping up. Read it with atomic.LoadUint64.
+	Dropped uint64
+
+	user    *User
+	server  string
+	channel string
+}
+
+// Subscribe registers a Subscription for messages logged to channel on
+// server. LogMessage fans out to it without blocking on slow consumers,
+// dropping the oldest buffered message to make room for the newest.
+func (u *User) Subscribe(server, channel string, opts SubscribeOptions) (*Subscription, error) {
+	bufSize := subscriptionBuffer
+	if opts.Replay > bufSize {
+		bufSize = opts.Replay
+	}
+
+	sub := &Subscription{
+		C:       make(chan Message, bufSize),
+		user:    u,
+		server:  server,
+		channel: channel,
+	}
+
+	if opts.Replay > 0 {
+		replay, err := u.GetLastMessages(server, channel, opts.Replay)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range replay {
+			sub.C <- msg
+		}
+	}
+
+	u.subsMu.Lock()
+	u.subs = append(u.subs, sub)
+	u.subsMu.Unlock()
+
+	return sub, nil
+}
+
+// Close unregisters the subscription. Further messages are no longer
+// delivered to C.
+func (s *Subscription) Close() error {
+	s.user.subsMu.Lock()
+	defer s.user.subsMu.Unlock()
+
+	subs := s.user.subs
+	for i, sub := range subs {
+		if sub == s {
+			s.user.subs = append(subs[:i], subs[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// publish fans msg out to every subscription matching its server and
+// channel, never blocking on a slow subscriber.
+func (u *User) publish(msg Message) {
+	u.subsMu.Lock()
+	defer u.subsMu.Unlock()
+
+	for _, sub := range u.subs {
+		if sub.server != msg.Server || sub.channel != msg.To {
+			continue
+		}
+		sub.deliver(msg)
+	}
+}
+
+func (s *Subscription) deliver(msg Message) {
+	select {
+	case s.C <- msg:
+		return
+	default:
+	}
+
+	select {
+	case <-s.C:
+		atomic.AddUint64(&s.Dropped, 1)
+	default:
+	}
+
+	select {
+	case s.C <- msg:
+	default:
+	}
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/khlieng/dispatch/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+)
+
+func TestSubscribe(t *testing.T) {
+	Initialize(tempdir())
+	Open()
+
+	user, err := NewUser()
+	assert.Nil(t, err)
+
+	subGoNuts, err := user.Subscribe("irc.freenode.net", "#go-nuts", SubscribeOptions{})
+	assert.Nil(t, err)
+	defer subGoNuts.Close()
+
+	subGeneral, err := user.Subscribe("irc.freenode.net", "#general", SubscribeOptions{})
+	assert.Nil(t, err)
+	defer subGeneral.Close()
+
+	assert.Nil(t, user.LogMessage("irc.freenode.net", "nick", "#go-nuts", "hello"))
+	assert.Nil(t, user.LogMessage("irc.freenode.net", "nick", "#go-nuts", "world"))
+	assert.Nil(t, user.LogMessage("irc.freenode.net", "nick", "#general", "unrelated"))
+
+	msg := recvMessage(t, subGoNuts.C)
+	assert.Equal(t, "hello", msg.Content)
+	msg = recvMessage(t, subGoNuts.C)
+	assert.Equal(t, "world", msg.Content)
+
+	select {
+	case msg := <-subGoNuts.C:
+		t.Errorf("unexpected message delivered to #go-nuts subscriber: %v", msg)
+	default:
+	}
+
+	msg = recvMessage(t, subGeneral.C)
+	assert.Equal(t, "unrelated", msg.Content)
+
+	Close()
+}
+
+func TestSubscribeReplay(t *testing.T) {
+	Initialize(tempdir())
+	Open()
+
+	user, err := NewUser()
+	assert.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, user.LogMessage("irc.freenode.net", "nick", "#go-nuts", "message"+strconv.Itoa(i)))
+	}
+
+	sub, err := user.Subscribe("irc.freenode.net", "#go-nuts", SubscribeOptions{Replay: 2})
+	assert.Nil(t, err)
+	defer sub.Close()
+
+	msg := recvMessage(t, sub.C)
+	assert.Equal(t, "message1", msg.Content)
+	msg = recvMessage(t, sub.C)
+	assert.Equal(t, "message2", msg.Content)
+
+	assert.Nil(t, user.LogMessage("irc.freenode.net", "nick", "#go-nuts", "message3"))
+
+	msg = recvMessage(t, sub.C)
+	assert.Equal(t, "message3", msg.Content)
+
+	Close()
+}
+
+func TestSubscribeDropsOldest(t *testing.T) {
+	Initialize(tempdir())
+	Open()
+
+	user, err := NewUser()
+	assert.Nil(t, err)
+
+	sub, err := user.Subscribe("irc.freenode.net", "#go-nuts", SubscribeOptions{})
+	assert.Nil(t, err)
+	defer sub.Close()
+
+	total := subscriptionBuffer + 5
+	for i := 0; i < total; i++ {
+		assert.Nil(t, user.LogMessage("irc.freenode.net", "nick", "#go-nuts", "msg"+strconv.Itoa(i)))
+	}
+
+	assert.True(t, atomic.LoadUint64(&sub.Dropped) >= 5)
+
+	var last Message
+	draining := true
+	for draining {
+		select {
+		case last = <-sub.C:
+		default:
+			draining = false
+		}
+	}
+	assert.Equal(t, "msg"+strconv.Itoa(total-1), last.Content)
+
+	Close()
+}
+
+func recvMessage(t *testing.T, c chan Message) Message {
+	select {
+	case msg := <-c:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("message not delivered")
+		return Message{}
+	}
+}
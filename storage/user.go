@@ -0,0 +1,312 @@
+package storage
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/khlieng/dispatch/Godeps/_workspace/src/github.com/boltdb/bolt"
+)
+
+var (
+	bucketServers  = []byte("servers")
+	bucketChannels = []byte("channels")
+	bucketLogs     = []byte("logs")
+	bucketIndex    = []byte("index")
+	bucketMeta     = []byte("meta")
+	bucketCerts    = []byte("certs")
+)
+
+// User owns a set of servers, channels and logged messages, all stored in
+// a single BoltDB file under its own directory.
+type User struct {
+	ID uint64
+	db *bolt.DB
+
+	subsMu sync.Mutex
+	subs   []*Subscription
+}
+
+func openUser(id uint64) (*User, error) {
+	dir := userDir(id)
+	if err := createDir(dir); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(dir+"/user.db", 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketServers, bucketChannels, bucketLogs, bucketIndex, bucketMeta, bucketCerts} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := migrateSearchIndex(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &User{ID: id, db: db}, nil
+}
+
+// Close closes the user's database.
+func (u *User) Close() error {
+	return u.db.Close()
+}
+
+// AddServer stores a server configuration for this user.
+func (u *User) AddServer(srv Server) error {
+	return u.put(bucketServers, []byte(srv.Host), srv)
+}
+
+// GetServers returns every server configured for this user, ordered by
+// host.
+func (u *User) GetServers() []Server {
+	var servers []Server
+
+	u.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketServers).ForEach(func(k, v []byte) error {
+			var srv Server
+			if err := json.Unmarshal(v, &srv); err != nil {
+				return err
+			}
+			servers = append(servers, srv)
+			return nil
+		})
+	})
+
+	return servers
+}
+
+// RemoveServer deletes a server and every channel logged under it.
+func (u *User) RemoveServer(host string) error {
+	return u.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucketServers).Delete([]byte(host)); err != nil {
+			return err
+		}
+
+		channels := tx.Bucket(bucketChannels)
+		c := channels.Cursor()
+		prefix := []byte(host + "\x00")
+		for k, _ := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, _ = c.Next() {
+			if err := channels.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// SetNick updates the nick stored for the given server.
+func (u *User) SetNick(nick, host string) error {
+	return u.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketServers)
+		v := bucket.Get([]byte(host))
+		if v == nil {
+			return nil
+		}
+
+		var srv Server
+		if err := json.Unmarshal(v, &srv); err != nil {
+			return err
+		}
+		srv.Nick = nick
+
+		return putJSON(bucket, []byte(host), srv)
+	})
+}
+
+// SetPassword updates the server password stored for the given server.
+func (u *User) SetPassword(password, host string) error {
+	return u.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketServers)
+		v := bucket.Get([]byte(host))
+		if v == nil {
+			return nil
+		}
+
+		var srv Server
+		if err := json.Unmarshal(v, &srv); err != nil {
+			return err
+		}
+		srv.Password = password
+
+		return putJSON(bucket, []byte(host), srv)
+	})
+}
+
+// SetSASL updates the SASL credentials stored for the given server.
+func (u *User) SetSASL(mechanism, username, password, host string) error {
+	return u.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketServers)
+		v := bucket.Get([]byte(host))
+		if v == nil {
+			return nil
+		}
+
+		var srv Server
+		if err := json.Unmarshal(v, &srv); err != nil {
+			return err
+		}
+		srv.SASL = true
+		srv.SASLMechanism = mechanism
+		srv.SASLUsername = username
+		srv.SASLPassword = password
+
+		return putJSON(bucket, []byte(host), srv)
+	})
+}
+
+// AddChannel stores a channel this user has joined.
+func (u *User) AddChannel(channel Channel) error {
+	return u.put(bucketChannels, channelKey(channel.Server, channel.Name), channel)
+}
+
+// GetChannels returns every channel this user has joined, ordered by
+// server then name.
+func (u *User) GetChannels() []Channel {
+	var channels []Channel
+
+	u.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketChannels).ForEach(func(k, v []byte) error {
+			var channel Channel
+			if err := json.Unmarshal(v, &channel); err != nil {
+				return err
+			}
+			channels = append(channels, channel)
+			return nil
+		})
+	})
+
+	return channels
+}
+
+// RemoveChannel deletes a channel the user has left.
+func (u *User) RemoveChannel(server, name string) error {
+	return u.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketChannels).Delete(channelKey(server, name))
+	})
+}
+
+func channelKey(server, name string) []byte {
+	return []byte(server + "\x00" + name)
+}
+
+func (u *User) put(bucket []byte, key []byte, v interface{}) error {
+	return u.db.Update(func(tx *bolt.Tx) error {
+		return putJSON(tx.Bucket(bucket), key, v)
+	})
+}
+
+func putJSON(bucket *bolt.Bucket, key []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, data)
+}
+
+// LogMessage appends a message to the log for the given server/channel,
+// indexes its content for SearchMessages and fans it out to any matching
+// Subscriptions.
+func (u *User) LogMessage(server, from, channel, content string) error {
+	var msg Message
+
+	err := u.db.Update(func(tx *bolt.Tx) error {
+		logs, err := tx.Bucket(bucketLogs).CreateBucketIfNotExists(logKey(server, channel))
+		if err != nil {
+			return err
+		}
+
+		seq, err := logs.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		msg = Message{
+			ID:      seq,
+			Server:  server,
+			From:    from,
+			To:      channel,
+			Content: content,
+			Time:    time.Now(),
+		}
+
+		if err := putJSON(logs, idToBytes(seq), msg); err != nil {
+			return err
+		}
+
+		index, err := tx.Bucket(bucketIndex).CreateBucketIfNotExists(logKey(server, channel))
+		if err != nil {
+			return err
+		}
+
+		return indexTokens(index, tokenize(content), seq)
+	})
+	if err != nil {
+		return err
+	}
+
+	u.publish(msg)
+
+	return nil
+}
+
+// GetMessages returns up to count messages logged before lastID, in
+// chronological order.
+func (u *User) GetMessages(server, channel string, count int, lastID uint64) ([]Message, error) {
+	var messages []Message
+
+	err := u.db.View(func(tx *bolt.Tx) error {
+		logs := tx.Bucket(bucketLogs).Bucket(logKey(server, channel))
+		if logs == nil {
+			return nil
+		}
+
+		var before []Message
+		err := logs.ForEach(func(k, v []byte) error {
+			var msg Message
+			if err := json.Unmarshal(v, &msg); err != nil {
+				return err
+			}
+			if msg.ID < lastID {
+				before = append(before, msg)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(before) > count {
+			before = before[len(before)-count:]
+		}
+		messages = before
+
+		return nil
+	})
+
+	return messages, err
+}
+
+// GetLastMessages returns the last count messages logged, in
+// chronological order.
+func (u *User) GetLastMessages(server, channel string, count int) ([]Message, error) {
+	return u.GetMessages(server, channel, count, ^uint64(0))
+}
+
+func logKey(server, channel string) []byte {
+	return []byte(server + "\x00" + channel)
+}
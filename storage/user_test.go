@@ -61,6 +61,13 @@ func TestUser(t *testing.T) {
 	user.SetNick("bob", srv.Host)
 	assert.Equal(t, "bob", user.GetServers()[0].Nick)
 
+	user.SetSASL("PLAIN", "bob", "hunter2", srv.Host)
+	saslSrv := user.GetServers()[0]
+	assert.True(t, saslSrv.SASL)
+	assert.Equal(t, "PLAIN", saslSrv.SASLMechanism)
+	assert.Equal(t, "bob", saslSrv.SASLUsername)
+	assert.Equal(t, "hunter2", saslSrv.SASLPassword)
+
 	user.RemoveChannel(srv.Host, chan1.Name)
 	channels = user.GetChannels()
 	assert.Len(t, channels, 1)
@@ -86,9 +93,9 @@ func TestMessages(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Len(t, messages, 0)
 
-	messages, err = user.SearchMessages("irc.freenode.net", "#go-nuts", "message")
+	result, err := user.SearchMessages("irc.freenode.net", "#go-nuts", "message", SearchOptions{})
 	assert.Nil(t, err)
-	assert.Len(t, messages, 0)
+	assert.Len(t, result.Messages, 0)
 
 	for i := 0; i < 5; i++ {
 		err = user.LogMessage("irc.freenode.net", "nick", "#go-nuts", "message"+strconv.Itoa(i))
@@ -125,9 +132,75 @@ func TestMessages(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Len(t, messages, 3)
 
-	messages, err = user.SearchMessages("irc.freenode.net", "#go-nuts", "message")
+	result, err = user.SearchMessages("irc.freenode.net", "#go-nuts", "message", SearchOptions{})
 	assert.Nil(t, err)
-	assert.Len(t, messages, 5)
+	assert.Len(t, result.Messages, 5)
+	assert.Equal(t, 5, result.Total)
+	// Newest first.
+	assert.Equal(t, "message4", result.Messages[0].Content)
+	assert.Equal(t, "message0", result.Messages[4].Content)
+	assert.NotEmpty(t, result.Messages[0].Highlights)
+
+	result, err = user.SearchMessages("irc.freenode.net", "#go-nuts", "message", SearchOptions{Limit: 2})
+	assert.Nil(t, err)
+	assert.Len(t, result.Messages, 2)
+	assert.Equal(t, 5, result.Total)
+	assert.Equal(t, "message4", result.Messages[0].Content)
+	assert.Equal(t, uint64(4), result.Cursor)
+
+	next, err := user.SearchMessages("irc.freenode.net", "#go-nuts", "message",
+		SearchOptions{Limit: 2, BeforeID: result.Cursor})
+	assert.Nil(t, err)
+	assert.Len(t, next.Messages, 2)
+	assert.Equal(t, "message2", next.Messages[0].Content)
+
+	err = user.LogMessage("irc.freenode.net", "bob", "#go-nuts", "hello from bob")
+	assert.Nil(t, err)
+
+	result, err = user.SearchMessages("irc.freenode.net", "#go-nuts", "hello", SearchOptions{Nick: "bob"})
+	assert.Nil(t, err)
+	assert.Len(t, result.Messages, 1)
+	assert.Equal(t, "hello from bob", result.Messages[0].Content)
+
+	result, err = user.SearchMessages("irc.freenode.net", "#go-nuts", "hello", SearchOptions{Nick: "nick"})
+	assert.Nil(t, err)
+	assert.Len(t, result.Messages, 0)
+
+	err = user.LogMessage("irc.freenode.net", "nick", "#general", "hello from general")
+	assert.Nil(t, err)
+
+	result, err = user.SearchMessages("irc.freenode.net", "", "hello", SearchOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, result.Messages, 2)
+
+	// #other's first logged message collides on id (1) with #general's,
+	// exercising cross-channel pagination where Cursor alone can't tell
+	// the two apart.
+	err = user.LogMessage("irc.freenode.net", "nick", "#other", "hello from other")
+	assert.Nil(t, err)
+
+	result, err = user.SearchMessages("irc.freenode.net", "", "hello", SearchOptions{Limit: 1})
+	assert.Nil(t, err)
+	assert.Len(t, result.Messages, 1)
+	assert.Equal(t, 3, result.Total)
+	assert.Equal(t, "hello from other", result.Messages[0].Content)
+
+	page2, err := user.SearchMessages("irc.freenode.net", "", "hello",
+		SearchOptions{Limit: 1, BeforeID: result.Cursor, BeforeChannel: result.CursorChannel})
+	assert.Nil(t, err)
+	assert.Len(t, page2.Messages, 1)
+	assert.Equal(t, "hello from general", page2.Messages[0].Content)
+
+	page3, err := user.SearchMessages("irc.freenode.net", "", "hello",
+		SearchOptions{Limit: 1, BeforeID: page2.Cursor, BeforeChannel: page2.CursorChannel})
+	assert.Nil(t, err)
+	assert.Len(t, page3.Messages, 1)
+	assert.Equal(t, "hello from bob", page3.Messages[0].Content)
+
+	// A cross-channel BeforeID without its BeforeChannel is ambiguous
+	// and must error rather than silently returning page 1 again.
+	_, err = user.SearchMessages("irc.freenode.net", "", "hello", SearchOptions{BeforeID: result.Cursor})
+	assert.Error(t, err)
 
 	Close()
 }
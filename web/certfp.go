@@ -0,0 +1,31 @@
+// Package web exposes small HTTP endpoints bridging a user's stored
+// state to the outside world.
+package web
+
+import (
+	"net/http"
+
+	"github.com/khlieng/dispatch/storage"
+)
+
+// CertFingerprintHandler serves the SHA-256 fingerprint of the client
+// certificate user holds for server, in the hex format NickServ expects
+// for CERT ADD.
+func CertFingerprintHandler(user *storage.User, server string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cert, err := user.Cert(server)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		fingerprint, err := storage.Fingerprint(cert)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(fingerprint))
+	}
+}
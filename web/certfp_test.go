@@ -0,0 +1,52 @@
+package web
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/khlieng/dispatch/Godeps/_workspace/src/github.com/stretchr/testify/assert"
+	"github.com/khlieng/dispatch/storage"
+)
+
+func tempdir() string {
+	f, _ := ioutil.TempDir("", "")
+	return f
+}
+
+func TestCertFingerprintHandler(t *testing.T) {
+	storage.Initialize(tempdir())
+	storage.Open()
+	defer storage.Close()
+
+	user, err := storage.NewUser()
+	assert.Nil(t, err)
+
+	fingerprint, err := user.GenerateCert("irc.freenode.net")
+	assert.Nil(t, err)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+
+	CertFingerprintHandler(user, "irc.freenode.net")(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, fingerprint, rec.Body.String())
+}
+
+func TestCertFingerprintHandlerMissing(t *testing.T) {
+	storage.Initialize(tempdir())
+	storage.Open()
+	defer storage.Close()
+
+	user, err := storage.NewUser()
+	assert.Nil(t, err)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+
+	CertFingerprintHandler(user, "irc.freenode.net")(rec, req)
+
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}